@@ -3,27 +3,82 @@ package hdfs
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/colinmarc/hdfs"
+	"github.com/colinmarc/hdfs/hadoopconf"
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
 	"github.com/docker/distribution/registry/storage/driver/base"
 	"github.com/docker/distribution/registry/storage/driver/factory"
+	"github.com/docker/distribution/uuid"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/jcmturner/gokrb5.v7/client"
+	krb5config "gopkg.in/jcmturner/gokrb5.v7/config"
+	"gopkg.in/jcmturner/gokrb5.v7/keytab"
 )
 
 const (
-	driverName      = "hdfs"
-	paramNameNode   = "namenode"
-	paramRootDir    = "rootdirectory"
-	paramMaxClients = "maxClients"
+	driverName                  = "hdfs"
+	paramNameNode               = "namenode"
+	paramRootDir                = "rootdirectory"
+	paramMaxClients             = "maxClients"
+	paramWebHDFSEndpoint        = "webhdfsendpoint"
+	paramWebHDFSUser            = "webhdfsuser"
+	paramWebHDFSSecret          = "webhdfssecret"
+	paramKerberosPrincipal      = "kerberosprincipal"
+	paramKeytabPath             = "keytabpath"
+	paramKrb5ConfPath           = "krb5confpath"
+	paramDataTransferProtection = "datatransferprotection"
+	paramServicePrincipalName   = "serviceprincipalname"
+	paramReplication            = "replication"
+	paramBlockSize              = "blocksize"
+	paramFilePermissions        = "filepermissions"
+	paramDirPermissions         = "dirpermissions"
+	paramWalkParallelism        = "walkparallelism"
 
 	// defaultMaxClients is the maximal value for the maxClients configuration
 	defaultMaxClients = uint64(1024)
+
+	// defaultURLExpiry is how long a URLFor link stays valid when the caller
+	// doesn't pass an "expiry" option.
+	defaultURLExpiry = 20 * time.Minute
+
+	// staleUploadPrefix marks a temp file Writer creates for its
+	// write-then-rename commit. reapStaleUploads looks for this prefix to
+	// clean up temp files left behind by a crash between Writer and Commit.
+	staleUploadPrefix = ".upload-"
+
+	// defaultStaleUploadAge is how old a temp upload must be before
+	// reapStaleUploads will remove it.
+	defaultStaleUploadAge = 24 * time.Hour
+
+	// defaultReplication and defaultBlockSize of 0 tell HDFS to fall back to
+	// the cluster-wide dfs.replication / dfs.blocksize.
+	defaultReplication = int16(0)
+	defaultBlockSize   = int64(0)
+	// defaultFilePermissions matches what client.Create used before this was
+	// configurable.
+	defaultFilePermissions = os.FileMode(0644)
+	// defaultDirPermissions matches what Move's MkdirAll used before this
+	// was configurable.
+	defaultDirPermissions = os.FileMode(0755)
+
+	// defaultWalkParallelism bounds how many directories Walk lists
+	// concurrently when the driver isn't configured with an explicit value.
+	defaultWalkParallelism = 8
 )
 
 // DriverConfig represents all configuration options available for the
@@ -32,6 +87,54 @@ type DriverConfig struct {
 	nameNode   string
 	rootPath   string
 	maxClients uint64
+
+	// webhdfsEndpoint is the base URL (scheme://host:port) of a WebHDFS or
+	// HttpFS gateway. When set, URLFor redirects pulls directly to it instead
+	// of returning ErrUnsupportedMethod.
+	webhdfsEndpoint string
+	// webhdfsUser is sent as the "user.name" pseudo-auth parameter when no
+	// webhdfsSecret is configured.
+	webhdfsUser string
+	// webhdfsSecret, when set, is used to HMAC-sign a time-bounded
+	// "signature" query parameter embedded in URLFor links instead of the
+	// plain user.name param. This is NOT a Hadoop delegation token: a plain
+	// WebHDFS/HttpFS gateway doesn't understand it, so it's only useful
+	// fronted by a proxy that verifies it and then authenticates to HDFS
+	// itself.
+	webhdfsSecret string
+
+	// kerberosPrincipal is the client principal (e.g. "hdfs/registry@REALM")
+	// used to authenticate to a kerberized NameNode. When empty, the driver
+	// falls back to HDFS's simple (unauthenticated) auth, as before.
+	kerberosPrincipal string
+	// keytabPath is the path to a keytab file containing a key for
+	// kerberosPrincipal.
+	keytabPath string
+	// krb5ConfPath is the path to the krb5.conf describing the realm(s) and
+	// KDC(s) to authenticate against.
+	krb5ConfPath string
+	// dataTransferProtection is the SASL QOP used for the DataNode data
+	// transfer protocol: "authentication", "integrity", or "privacy".
+	dataTransferProtection string
+	// servicePrincipalName is the NameNode's Kerberos service principal,
+	// e.g. "nn/_HOST@REALM".
+	servicePrincipalName string
+
+	// replication is the number of copies HDFS keeps of written files. 0
+	// uses the cluster's dfs.replication default.
+	replication int16
+	// blockSize is the HDFS block size, in bytes, for written files. 0 uses
+	// the cluster's dfs.blocksize default.
+	blockSize int64
+	// filePermissions is applied to files created by Writer.
+	filePermissions os.FileMode
+	// dirPermissions is applied to parent directories created by Writer and
+	// Move.
+	dirPermissions os.FileMode
+
+	// walkParallelism bounds how many directories Walk lists concurrently,
+	// each from its own pooled client.
+	walkParallelism int
 }
 
 func init() {
@@ -47,6 +150,7 @@ func (factory *hdfsDriverFactory) Create(parameters map[string]interface{}) (sto
 
 type driver struct {
 	config DriverConfig
+	pool   *clientPool
 }
 
 // baseEmbed allows us to hide the Base embed.
@@ -63,7 +167,39 @@ func FromParameters(parameters map[string]interface{}) (*Driver, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to build config: %+v", err)
 	}
-	return New(*config), nil
+	return New(*config)
+}
+
+// parsePermissions interprets a filepermissions/dirpermissions config value
+// as a Unix permission bit pattern. A string is parsed as octal, matching
+// the conventional way of writing a file mode (e.g. "0644"); any other type
+// is assumed to already be a decoded integer and used as-is. This matters
+// because an unquoted YAML value like "filepermissions: 0644" is decoded by
+// distribution's YAML 1.1 config loader as decimal 420, not octal 0644 -
+// reparsing fmt.Sprint(v) as base-8 would silently produce the wrong bits.
+func parsePermissions(name string, v interface{}) (os.FileMode, error) {
+	switch n := v.(type) {
+	case string:
+		p, err := strconv.ParseUint(n, 8, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%s config error: %s", name, err.Error())
+		}
+		return os.FileMode(p), nil
+	case int:
+		return os.FileMode(n), nil
+	case int32:
+		return os.FileMode(n), nil
+	case int64:
+		return os.FileMode(n), nil
+	case uint:
+		return os.FileMode(n), nil
+	case uint32:
+		return os.FileMode(n), nil
+	case uint64:
+		return os.FileMode(n), nil
+	default:
+		return 0, fmt.Errorf("%s config error: unsupported value type %T", name, v)
+	}
 }
 
 func buildConfig(parameters map[string]interface{}) (*DriverConfig, error) {
@@ -84,32 +220,173 @@ func buildConfig(parameters map[string]interface{}) (*DriverConfig, error) {
 		return nil, fmt.Errorf("maxClients config error: %s", err.Error())
 	}
 
+	var webhdfsEndpoint string
+	if v, ok := parameters[paramWebHDFSEndpoint]; ok && fmt.Sprint(v) != "" {
+		webhdfsEndpoint = strings.TrimRight(fmt.Sprint(v), "/")
+	}
+
+	var webhdfsUser string
+	if v, ok := parameters[paramWebHDFSUser]; ok && fmt.Sprint(v) != "" {
+		webhdfsUser = fmt.Sprint(v)
+	}
+
+	var webhdfsSecret string
+	if v, ok := parameters[paramWebHDFSSecret]; ok && fmt.Sprint(v) != "" {
+		webhdfsSecret = fmt.Sprint(v)
+	}
+
+	var kerberosPrincipal string
+	if v, ok := parameters[paramKerberosPrincipal]; ok && fmt.Sprint(v) != "" {
+		kerberosPrincipal = fmt.Sprint(v)
+	}
+
+	var keytabPath string
+	if v, ok := parameters[paramKeytabPath]; ok && fmt.Sprint(v) != "" {
+		keytabPath = fmt.Sprint(v)
+	}
+
+	var krb5ConfPath string
+	if v, ok := parameters[paramKrb5ConfPath]; ok && fmt.Sprint(v) != "" {
+		krb5ConfPath = fmt.Sprint(v)
+	}
+
+	if kerberosPrincipal != "" && (keytabPath == "" || krb5ConfPath == "") {
+		return nil, fmt.Errorf("%s requires both %s and %s to be set", paramKerberosPrincipal, paramKeytabPath, paramKrb5ConfPath)
+	}
+
+	dataTransferProtection := "authentication"
+	if v, ok := parameters[paramDataTransferProtection]; ok && fmt.Sprint(v) != "" {
+		dataTransferProtection = fmt.Sprint(v)
+	}
+
+	var servicePrincipalName string
+	if v, ok := parameters[paramServicePrincipalName]; ok && fmt.Sprint(v) != "" {
+		servicePrincipalName = fmt.Sprint(v)
+	}
+
+	replication := defaultReplication
+	if v, ok := parameters[paramReplication]; ok && fmt.Sprint(v) != "" {
+		r, err := strconv.ParseInt(fmt.Sprint(v), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("%s config error: %s", paramReplication, err.Error())
+		}
+		replication = int16(r)
+	}
+
+	blockSize := defaultBlockSize
+	if v, ok := parameters[paramBlockSize]; ok && fmt.Sprint(v) != "" {
+		b, err := strconv.ParseInt(fmt.Sprint(v), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s config error: %s", paramBlockSize, err.Error())
+		}
+		blockSize = b
+	}
+
+	filePermissions := defaultFilePermissions
+	if v, ok := parameters[paramFilePermissions]; ok && fmt.Sprint(v) != "" {
+		p, err := parsePermissions(paramFilePermissions, v)
+		if err != nil {
+			return nil, err
+		}
+		filePermissions = p
+	}
+
+	dirPermissions := defaultDirPermissions
+	if v, ok := parameters[paramDirPermissions]; ok && fmt.Sprint(v) != "" {
+		p, err := parsePermissions(paramDirPermissions, v)
+		if err != nil {
+			return nil, err
+		}
+		dirPermissions = p
+	}
+
+	walkParallelism := defaultWalkParallelism
+	if v, ok := parameters[paramWalkParallelism]; ok && fmt.Sprint(v) != "" {
+		w, err := strconv.Atoi(fmt.Sprint(v))
+		if err != nil {
+			return nil, fmt.Errorf("%s config error: %s", paramWalkParallelism, err.Error())
+		}
+		walkParallelism = w
+	}
+	// Each Walk worker holds a pooled client for the entire walk, so
+	// walkParallelism can't exceed maxClients without Walk alone starving
+	// every other concurrent driver call of pooled clients.
+	if walkParallelism > int(maxClients) {
+		log.Warningf("%s (%d) exceeds %s (%d); clamping to %d", paramWalkParallelism, walkParallelism, paramMaxClients, maxClients, maxClients)
+		walkParallelism = int(maxClients)
+	}
+
 	return &DriverConfig{
-		nameNode:   fmt.Sprint(nn),
-		rootPath:   fmt.Sprint(root),
-		maxClients: maxClients,
+		nameNode:               fmt.Sprint(nn),
+		rootPath:               fmt.Sprint(root),
+		maxClients:             maxClients,
+		webhdfsEndpoint:        webhdfsEndpoint,
+		webhdfsUser:            webhdfsUser,
+		webhdfsSecret:          webhdfsSecret,
+		kerberosPrincipal:      kerberosPrincipal,
+		keytabPath:             keytabPath,
+		krb5ConfPath:           krb5ConfPath,
+		dataTransferProtection: dataTransferProtection,
+		servicePrincipalName:   servicePrincipalName,
+		replication:            replication,
+		blockSize:              blockSize,
+		filePermissions:        filePermissions,
+		dirPermissions:         dirPermissions,
+		walkParallelism:        walkParallelism,
 	}, nil
 }
 
 // Driver is a storagedriver.StorageDriver implementation backed by HDFS.
 type Driver struct {
 	baseEmbed // embedded, hidden base driver.
+
+	pool *clientPool
 }
 
 var _ storagedriver.StorageDriver = &Driver{}
 
 // New constructs a new Driver.
-func New(config DriverConfig) *Driver {
+func New(config DriverConfig) (*Driver, error) {
 	hdfsDriver := &driver{config: config}
+
+	var krb5Client *client.Client
+	if config.kerberosPrincipal != "" {
+		kc, err := newKerberosClient(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain Kerberos ticket for %s: %v", config.kerberosPrincipal, err)
+		}
+		krb5Client = kc
+	}
+
+	hdfsDriver.pool = newClientPool(config.maxClients, krb5Client, func() (*hdfs.Client, error) {
+		return newHdfsClient(config, krb5Client)
+	})
 	log.Infof("created HDFS driver with config %+v", config)
 
+	go func() {
+		if err := hdfsDriver.reapStaleUploads(context.Background(), defaultStaleUploadAge); err != nil {
+			log.Errorf("failed to reap stale uploads under %s: %v", config.rootPath, err)
+		}
+	}()
+
 	return &Driver{
 		baseEmbed: baseEmbed{
 			Base: base.Base{
 				StorageDriver: base.NewRegulator(hdfsDriver, config.maxClients),
 			},
 		},
-	}
+		pool: hdfsDriver.pool,
+	}, nil
+}
+
+// Close stops the driver's background goroutines (idle-client eviction and,
+// for Kerberos-authenticated pools, ticket renewal) and closes its idle
+// pooled connections. Callers that construct a Driver via New should Close
+// it once they're done, e.g. on configuration reload or process shutdown,
+// to avoid leaking those goroutines.
+func (d *Driver) Close() error {
+	d.pool.close()
+	return nil
 }
 
 func (d *driver) Name() string {
@@ -120,15 +397,65 @@ func (d *driver) fullPath(subPath string) string {
 	return path.Join(d.config.rootPath, subPath)
 }
 
-func (d *driver) newWriter(client *hdfs.Client, fullPath string, hdfsFileWriter *hdfs.FileWriter, offset int64) storagedriver.FileWriter {
+// acquireClient hands out a pooled *hdfs.Client, reusing an idle connection
+// to the configured NameNode when one is available. It blocks until a
+// client is available or ctx is done if the pool is already at maxClients.
+func (d *driver) acquireClient(ctx context.Context) (*hdfs.Client, error) {
+	return d.pool.get(ctx)
+}
+
+// releaseClient returns a client to the pool for reuse by a later call.
+func (d *driver) releaseClient(client *hdfs.Client) {
+	d.pool.put(client)
+}
+
+// discardClient removes a client from the pool's open count and closes it,
+// instead of returning it to the idle set. Use this when a caller has
+// determined the client's connection is no longer usable.
+func (d *driver) discardClient(client *hdfs.Client) {
+	d.pool.discard(client)
+}
+
+// release returns client to the pool, unless err indicates its NameNode
+// connection has gone away, in which case the client is discarded so a
+// fresh one is dialed next time.
+func (d *driver) release(client *hdfs.Client, err error) {
+	if isDeadConnErr(err) {
+		d.discardClient(client)
+		return
+	}
+	d.releaseClient(client)
+}
+
+func (d *driver) newWriter(client *hdfs.Client, fullPath, tempPath string, hdfsFileWriter *hdfs.FileWriter, offset int64) storagedriver.FileWriter {
 	return &fileWriter{
-		client: client,
-		path:   fullPath,
-		file:   hdfsFileWriter,
-		size:   offset,
+		driver:   d,
+		client:   client,
+		path:     fullPath,
+		tempPath: tempPath,
+		file:     hdfsFileWriter,
+		size:     offset,
 	}
 }
 
+// fileReader wraps an *hdfs.FileReader so that closing it returns the
+// underlying client to the driver's pool instead of leaking it.
+type fileReader struct {
+	driver *driver
+	client *hdfs.Client
+	file   *hdfs.FileReader
+}
+
+func (r *fileReader) Read(p []byte) (int, error) {
+	return r.file.Read(p)
+}
+
+func (r *fileReader) Close() error {
+	err := r.file.Close()
+	r.driver.release(r.client, err)
+	return err
+}
+
 // GetContent retrieves the content stored at "path" as a []byte.
 func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
 	rc, err := d.Reader(ctx, path, 0)
@@ -162,13 +489,14 @@ func (d *driver) PutContent(ctx context.Context, subPath string, contents []byte
 // Reader retrieves an io.ReadCloser for the content stored at "path" with a
 // given byte offset.
 func (d *driver) Reader(ctx context.Context, subPath string, offset int64) (io.ReadCloser, error) {
-	client, err := newHdfsClient(d.config.nameNode)
+	client, err := d.acquireClient(ctx)
 	if err != nil {
 		return nil, err
 	}
 	fullPath := d.fullPath(subPath)
 	file, err := client.Open(fullPath)
 	if err != nil {
+		d.release(client, err)
 		if os.IsNotExist(err) {
 			return nil, storagedriver.PathNotFoundError{Path: fullPath}
 		}
@@ -179,66 +507,67 @@ func (d *driver) Reader(ctx context.Context, subPath string, offset int64) (io.R
 	seekPos, err := file.Seek(offset, io.SeekStart)
 	if err != nil {
 		file.Close()
+		d.release(client, err)
 		return nil, err
 	} else if seekPos < offset {
 		file.Close()
+		d.releaseClient(client)
 		return nil, storagedriver.InvalidOffsetError{Path: fullPath, Offset: offset}
 	}
-	return file, nil
+	return &fileReader{file: file, client: client, driver: d}, nil
 }
 
 // Writer returns a FileWriter which will store the content written to it
 // at the location designated by "path" after the call to Commit.
-func (d *driver) Writer(ctx context.Context, subPath string, append bool) (storagedriver.FileWriter, error) {
+func (d *driver) Writer(ctx context.Context, subPath string, append bool) (_ storagedriver.FileWriter, err error) {
 	fullPath := d.fullPath(subPath)
 	parentDir := path.Dir(fullPath)
 
-	client, err := newHdfsClient(d.config.nameNode)
+	client, err := d.acquireClient(ctx)
 	if err != nil {
 		log.Errorf("failed to create client: %v", err)
 		return nil, err
 	}
+	defer func() {
+		if err != nil {
+			d.release(client, err)
+		}
+	}()
 
-	if err := client.MkdirAll(parentDir, 0777); err != nil {
+	if err = client.MkdirAll(parentDir, d.config.dirPermissions); err != nil {
 		log.Errorf("failed to create parent directory: %v", err)
 		return nil, err
 	}
 
 	var file *hdfs.FileWriter
 	var size int64
+	var tempPath string
 
-	fileInfo, err := client.Stat(fullPath)
-	if err != nil {
-		if !os.IsNotExist(err) {
+	if append {
+		// Appends extend the existing final file in place: there's no new
+		// content to stage, so there's nothing for Commit to rename.
+		fileInfo, statErr := client.Stat(fullPath)
+		if statErr != nil {
+			err = statErr
 			log.Errorf("failed to stat file: %v", err)
 			return nil, err
 		}
-	} else {
-		// file exists
-		if append {
-			// if in append mode, record the current size
-			size = fileInfo.Size()
-		} else {
-			// if not in append mode, we need to truncate the file by deleting and recreating the file
-			if err := client.Remove(fullPath); err != nil {
-				log.Errorf("failed to delete file: %v", err)
-				return nil, err
-			}
-		}
-	}
+		size = fileInfo.Size()
 
-	if append {
 		file, err = client.Append(fullPath)
 		if err != nil {
 			log.Errorf("failed to open file in append mode: %v", err)
 			return nil, err
 		}
 	} else {
-		file, err = client.Create(fullPath)
+		// Write to a hidden sibling path and leave fullPath untouched until
+		// Commit renames it into place, so a crash between here and Commit
+		// never leaves a partially written blob at fullPath.
+		tempPath = path.Join(parentDir, staleUploadPrefix+uuid.Generate().String())
+
+		file, err = client.CreateFile(tempPath, int(d.config.replication), d.config.blockSize, d.config.filePermissions)
 		if err != nil {
-			if !os.IsExist(err) {
-				log.Errorf("failed to create file: %v", err)
-			}
+			log.Errorf("failed to create file: %v", err)
 			return nil, err
 		}
 
@@ -246,18 +575,19 @@ func (d *driver) Writer(ctx context.Context, subPath string, append bool) (stora
 		size = 0
 	}
 
-	return d.newWriter(client, fullPath, file, size), nil
+	return d.newWriter(client, fullPath, tempPath, file, size), nil
 }
 
 // Stat returns info about the provided path.
 func (d *driver) Stat(ctx context.Context, subPath string) (storagedriver.FileInfo, error) {
 	fullPath := d.fullPath(subPath)
-	client, err := newHdfsClient(d.config.nameNode)
+	client, err := d.acquireClient(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	fi, err := client.Stat(fullPath)
+	d.release(client, err)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, storagedriver.PathNotFoundError{Path: subPath}
@@ -273,20 +603,22 @@ func (d *driver) Stat(ctx context.Context, subPath string) (storagedriver.FileIn
 // path.
 func (d *driver) List(ctx context.Context, subPath string) ([]string, error) {
 	fullPath := d.fullPath(subPath)
-	client, err := newHdfsClient(d.config.nameNode)
+	client, err := d.acquireClient(ctx)
 	if err != nil {
 		return nil, err
 	}
 	dir, err := client.Open(fullPath)
 	if err != nil {
+		d.release(client, err)
 		if os.IsNotExist(err) {
 			return nil, storagedriver.PathNotFoundError{Path: subPath}
 		}
 		return nil, err
 	}
-	defer dir.Close()
 
 	fileNames, err := dir.Readdirnames(0)
+	dir.Close()
+	d.release(client, err)
 	if err != nil {
 		return nil, err
 	}
@@ -305,16 +637,17 @@ func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) e
 	source := d.fullPath(sourcePath)
 	dest := d.fullPath(destPath)
 
-	client, err := newHdfsClient(d.config.nameNode)
+	client, err := d.acquireClient(ctx)
 	if err != nil {
 		return err
 	}
+	defer func() { d.release(client, err) }()
 
-	if _, err := client.Stat(source); os.IsNotExist(err) {
+	if _, err = client.Stat(source); os.IsNotExist(err) {
 		return storagedriver.PathNotFoundError{Path: sourcePath}
 	}
 
-	if err := client.MkdirAll(path.Dir(dest), 0755); err != nil {
+	if err = client.MkdirAll(path.Dir(dest), d.config.dirPermissions); err != nil {
 		return err
 	}
 
@@ -325,10 +658,11 @@ func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) e
 // Delete recursively deletes all objects stored at "path" and its subpaths.
 func (d *driver) Delete(ctx context.Context, subPath string) error {
 	fullPath := d.fullPath(subPath)
-	client, err := newHdfsClient(d.config.nameNode)
+	client, err := d.acquireClient(ctx)
 	if err != nil {
 		return err
 	}
+	defer func() { d.release(client, err) }()
 
 	_, err = client.Stat(fullPath)
 	if err != nil && !os.IsNotExist(err) {
@@ -343,24 +677,296 @@ func (d *driver) Delete(ctx context.Context, subPath string) error {
 
 // URLFor returns a URL which may be used to retrieve the content stored at the given path.
 // May return an UnsupportedMethodErr in certain StorageDriver implementations.
-func (d *driver) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
-	return "", storagedriver.ErrUnsupportedMethod{DriverName: driverName}
+//
+// When webhdfsEndpoint is configured, this returns a WebHDFS "op=OPEN" URL:
+// HDFS's WebHDFS/HttpFS REST interface 307-redirects such a request straight
+// to the DataNode holding the data, so the caller can fetch the blob without
+// streaming it through the registry process. When webhdfsSecret is set, the
+// URL carries an HMAC "signature" query parameter instead of the plain
+// user.name param; this is a signing scheme specific to a fronting proxy
+// that checks it before authenticating to HDFS on the caller's behalf, not a
+// real Hadoop delegation token, so it isn't understood by a bare WebHDFS or
+// HttpFS gateway.
+func (d *driver) URLFor(ctx context.Context, subPath string, options map[string]interface{}) (string, error) {
+	if d.config.webhdfsEndpoint == "" {
+		return "", storagedriver.ErrUnsupportedMethod{DriverName: driverName}
+	}
+
+	if method, ok := options["method"]; ok {
+		methodString, ok := method.(string)
+		if !ok || methodString != "GET" {
+			return "", storagedriver.ErrUnsupportedMethod{DriverName: driverName}
+		}
+	}
+
+	expiresTime := time.Now().Add(defaultURLExpiry)
+	if expiry, ok := options["expiry"]; ok {
+		if et, ok := expiry.(time.Time); ok {
+			expiresTime = et
+		}
+	}
+
+	fullPath := d.fullPath(subPath)
+	u, err := url.Parse(d.config.webhdfsEndpoint + "/webhdfs/v1" + fullPath)
+	if err != nil {
+		return "", err
+	}
+
+	expires := expiresTime.Unix()
+	q := u.Query()
+	q.Set("op", "OPEN")
+	switch {
+	case d.config.webhdfsSecret != "":
+		q.Set("signature", d.urlSignature(fullPath, expires))
+	case d.config.webhdfsUser != "":
+		q.Set("user.name", d.config.webhdfsUser)
+	}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// urlSignature HMAC-signs path and expires with webhdfsSecret, so a proxy
+// fronting the WebHDFS gateway can reject a tampered or expired URLFor link
+// before authenticating the request to HDFS itself. It is not a Hadoop
+// delegation token and carries no meaning to a bare WebHDFS/HttpFS gateway.
+func (d *driver) urlSignature(path string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(d.config.webhdfsSecret))
+	fmt.Fprintf(mac, "%s:%d", path, expires)
+	return hex.EncodeToString(mac.Sum(nil))
 }
 
-// Walk traverses a filesystem defined within driver, starting from the given path, calling f on each file
-func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
-	return storagedriver.WalkFallback(ctx, d, path, f)
+// walkListing is one worker's result for a single directory: either its
+// full entries (so Walk never needs a follow-up Stat) or the error hit
+// listing it.
+type walkListing struct {
+	dir     string
+	entries []os.FileInfo
+	err     error
+}
+
+// Walk traverses a filesystem defined within driver, starting from the
+// given path, calling f on each file. Rather than delegating to
+// storagedriver.WalkFallback, which lists one directory at a time (and
+// therefore hits the NameNode serially for every subtree), this fans
+// directory listings out across a bounded pool of workers, each holding
+// its own pooled client for the duration of the walk.
+func (d *driver) Walk(ctx context.Context, from string, f storagedriver.WalkFn) error {
+	rootInfo, err := d.Stat(ctx, from)
+	if err != nil {
+		return err
+	}
+	if !rootInfo.IsDir() {
+		return f(rootInfo)
+	}
+
+	parallelism := d.config.walkParallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	jobs := make(chan string)
+	listings := make(chan walkListing)
+
+	workerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(parallelism)
+	for i := 0; i < parallelism; i++ {
+		go func() {
+			defer wg.Done()
+			d.walkWorker(workerCtx, jobs, listings)
+		}()
+	}
+
+	// queue holds directories that have been discovered but not yet handed
+	// to a worker. It's drained into jobs opportunistically: the select
+	// below only offers the send when the queue is non-empty, so receiving
+	// listings is never blocked behind a worker pool that's still busy.
+	queue := []string{d.fullPath(from)}
+	pending := 0
+
+	var walkErr error
+	for len(queue) > 0 || pending > 0 {
+		var sendJobs chan<- string
+		var nextDir string
+		if walkErr == nil && len(queue) > 0 {
+			sendJobs = jobs
+			nextDir = queue[0]
+		}
+
+		select {
+		case sendJobs <- nextDir:
+			queue = queue[1:]
+			pending++
+
+		case listing := <-listings:
+			pending--
+			if walkErr != nil {
+				continue
+			}
+			if listing.err != nil {
+				walkErr = listing.err
+				queue = nil
+				continue
+			}
+
+			sort.Slice(listing.entries, func(i, j int) bool {
+				return listing.entries[i].Name() < listing.entries[j].Name()
+			})
+
+			for _, entry := range listing.entries {
+				childFull := path.Join(listing.dir, entry.Name())
+				subPath := strings.TrimPrefix(childFull, d.config.rootPath)
+
+				if err := f(fromOSFileInfo(subPath, entry)); err != nil {
+					if err == storagedriver.ErrSkipDir && entry.IsDir() {
+						continue
+					}
+					walkErr = err
+					queue = nil
+					break
+				}
+
+				if entry.IsDir() {
+					queue = append(queue, childFull)
+				}
+			}
+
+		case <-ctx.Done():
+			walkErr = ctx.Err()
+			queue = nil
+			// Workers stop reporting once ctx is done, so don't wait for
+			// listings still outstanding.
+			pending = 0
+		}
+	}
+
+	close(jobs)
+	cancel()
+	wg.Wait()
+
+	return walkErr
+}
+
+// walkWorker pulls directories off jobs and lists each with a single
+// *hdfs.Client acquired once at startup, rather than paying pool
+// acquire/release overhead per directory. It reports one walkListing per
+// job until ctx is done, recreating its client if the NameNode connection
+// dies mid-walk.
+func (d *driver) walkWorker(ctx context.Context, jobs <-chan string, listings chan<- walkListing) {
+	client, err := d.acquireClient(ctx)
+	if err != nil {
+		for dir := range jobs {
+			select {
+			case listings <- walkListing{dir: dir, err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		return
+	}
+	defer func() { d.releaseClient(client) }()
+
+	for dir := range jobs {
+		entries, lerr := readDirInfos(client, dir)
+		if lerr != nil && isDeadConnErr(lerr) {
+			d.discardClient(client)
+			client, err = d.acquireClient(ctx)
+			if err != nil {
+				select {
+				case listings <- walkListing{dir: dir, err: err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+			entries, lerr = readDirInfos(client, dir)
+		}
+
+		select {
+		case listings <- walkListing{dir: dir, entries: entries, err: lerr}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// readDirInfos lists dir in a single RPC, returning full os.FileInfo for
+// every entry so Walk never needs a follow-up Stat per child.
+func readDirInfos(client *hdfs.Client, dir string) ([]os.FileInfo, error) {
+	f, err := client.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdir(0)
+}
+
+// reapStaleUploads removes write-then-rename temp files under rootPath that
+// are older than olderThan. A registry that crashes between Writer() and
+// Commit()/Cancel() leaves one of these behind; nothing ever references it
+// again, so it's safe to delete once it's old enough to rule out an upload
+// still in progress. It walks via the driver's own parallel, bulk-listing
+// Walk rather than the library's serial client.Walk, since rootPath can hold
+// millions of blobs.
+//
+// A rootPath that doesn't exist yet (e.g. the very first boot of a new
+// deployment, before anything has been written) isn't an error here: there's
+// nothing to reap.
+func (d *driver) reapStaleUploads(ctx context.Context, olderThan time.Duration) error {
+	cutoff := time.Now().Add(-olderThan)
+
+	err := d.Walk(ctx, "/", func(fileInfo storagedriver.FileInfo) error {
+		subPath := fileInfo.Path()
+		if fileInfo.IsDir() || !strings.HasPrefix(path.Base(subPath), staleUploadPrefix) {
+			return nil
+		}
+		if fileInfo.ModTime().After(cutoff) {
+			return nil
+		}
+
+		if rmErr := d.Delete(ctx, subPath); rmErr != nil {
+			if _, ok := rmErr.(storagedriver.PathNotFoundError); !ok {
+				log.Errorf("failed to reap stale upload %s: %v", d.fullPath(subPath), rmErr)
+			}
+		}
+		return nil
+	})
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
 }
 
 // fileWriter implements storagedriver.FileWriter interface
 type fileWriter struct {
-	client    *hdfs.Client
-	file      *hdfs.FileWriter
-	path      string
-	size      int64
-	closed    bool
-	committed bool
-	cancelled bool
+	driver *driver
+	client *hdfs.Client
+	file   *hdfs.FileWriter
+	// path is the final destination. tempPath, when non-empty, is the hidden
+	// sibling path actually being written to; Commit renames tempPath to path
+	// so a crash before Commit never leaves a partial blob at path. tempPath
+	// is empty for append writes, which write directly to path.
+	path           string
+	tempPath       string
+	size           int64
+	closed         bool
+	committed      bool
+	cancelled      bool
+	clientReleased bool
+}
+
+// releaseClient returns fw.client to the driver's pool exactly once,
+// regardless of how many of Close/Cancel end up touching it, discarding it
+// instead of recycling it if err indicates its NameNode connection has gone
+// away.
+func (fw *fileWriter) releaseClient(err error) {
+	if !fw.clientReleased {
+		fw.clientReleased = true
+		fw.driver.release(fw.client, err)
+	}
 }
 
 func (fw *fileWriter) Write(p []byte) (int, error) {
@@ -381,31 +987,37 @@ func (fw *fileWriter) Size() int64 {
 	return fw.size
 }
 
-func (fw *fileWriter) Close() error {
+func (fw *fileWriter) Close() (err error) {
 	if fw.closed {
 		return fmt.Errorf("already closed")
 	}
+	fw.closed = true
+	defer func() { fw.releaseClient(err) }()
 
-	if err := fw.file.Flush(); err != nil {
+	if err = fw.file.Flush(); err != nil {
 		return err
 	}
 
-	if err := fw.file.Close(); err != nil {
-		return err
-	}
-	fw.closed = true
-	return nil
+	err = fw.file.Close()
+	return err
 }
 
-func (fw *fileWriter) Cancel() error {
+func (fw *fileWriter) Cancel() (err error) {
 	if fw.closed {
 		return fmt.Errorf("already closed")
 	}
 
 	fw.cancelled = true
+	fw.closed = true
 	fw.file.Close()
+	defer func() { fw.releaseClient(err) }()
 
-	return fw.client.Remove(fw.path)
+	removePath := fw.path
+	if fw.tempPath != "" {
+		removePath = fw.tempPath
+	}
+	err = fw.client.Remove(removePath)
+	return err
 }
 
 func (fw *fileWriter) Commit() error {
@@ -421,13 +1033,86 @@ func (fw *fileWriter) Commit() error {
 		return err
 	}
 
+	if fw.tempPath != "" {
+		if err := fw.client.Rename(fw.tempPath, fw.path); err != nil {
+			if !os.IsExist(err) {
+				return err
+			}
+			// fw.path already has content from a previous write; replace it.
+			// This is not atomic: fw.path is briefly absent between Remove
+			// and Rename, but a reader never sees a partially written file.
+			if err := fw.client.Remove(fw.path); err != nil {
+				return err
+			}
+			if err := fw.client.Rename(fw.tempPath, fw.path); err != nil {
+				return err
+			}
+		}
+	}
+
 	fw.committed = true
 	return nil
 }
 
-// TODO(hluo): add hdfs client pool
-func newHdfsClient(nameNode string) (*hdfs.Client, error) {
-	return hdfs.New(nameNode)
+// newHdfsClient dials a new NameNode connection. It's used by the driver's
+// clientPool as the factory for connections that aren't available to reuse.
+// When config.kerberosPrincipal is set, the connection authenticates via
+// SASL using krb5Client's ticket instead of HDFS's simple auth.
+func newHdfsClient(config DriverConfig, krb5Client *client.Client) (*hdfs.Client, error) {
+	if config.kerberosPrincipal == "" {
+		return hdfs.New(config.nameNode)
+	}
+
+	options := hdfs.ClientOptionsFromConf(hadoopconf.HadoopConf{})
+	options.Addresses = []string{config.nameNode}
+	options.KerberosClient = krb5Client
+	options.KerberosServicePrincipleName = config.servicePrincipalName
+	options.DataTransferProtection = config.dataTransferProtection
+
+	c, err := hdfs.NewClient(options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to kerberized namenode %s: %v", config.nameNode, err)
+	}
+	return c, nil
+}
+
+// newKerberosClient loads config's keytab and krb5.conf and obtains a
+// ticket-granting-ticket for config.kerberosPrincipal. The returned client is
+// shared across every *hdfs.Client the pool dials for the lifetime of the
+// driver, and is kept current by clientPool's ticket renewal loop.
+func newKerberosClient(config DriverConfig) (*client.Client, error) {
+	krb5conf, err := krb5config.Load(config.krb5ConfPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load krb5 config %s: %v", config.krb5ConfPath, err)
+	}
+
+	kt, err := keytab.Load(config.keytabPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load keytab %s: %v", config.keytabPath, err)
+	}
+
+	username, realm := splitPrincipal(config.kerberosPrincipal)
+	if realm == "" {
+		realm = krb5conf.LibDefaults.DefaultRealm
+	}
+
+	krb5Client := client.NewClientWithKeytab(username, realm, kt, krb5conf)
+	if err := krb5Client.Login(); err != nil {
+		return nil, fmt.Errorf("failed to authenticate %s against KDC: %v", config.kerberosPrincipal, err)
+	}
+
+	return krb5Client, nil
+}
+
+// splitPrincipal splits a "user@REALM" or "user/instance@REALM" Kerberos
+// principal into its username and realm components.
+func splitPrincipal(principal string) (username, realm string) {
+	parts := strings.SplitN(principal, "@", 2)
+	username = parts[0]
+	if len(parts) == 2 {
+		realm = parts[1]
+	}
+	return username, realm
 }
 
 func fromOSFileInfo(path string, info os.FileInfo) storagedriver.FileInfo {
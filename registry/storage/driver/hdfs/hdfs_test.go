@@ -1,6 +1,8 @@
 package hdfs
 
 import (
+	"context"
+	"fmt"
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
 	"github.com/docker/distribution/registry/storage/driver/testsuites"
 	"gopkg.in/check.v1"
@@ -35,8 +37,53 @@ func init() {
 			rootPath:   hdfsRootPath,
 			maxClients: 8,
 		}
-		return New(config), nil
+		return New(config)
 	}
 
 	testsuites.RegisterSuite(hdfsDriverConstructor, skipHDFS)
 }
+
+// BenchmarkDriverWalk seeds a directory tree of b.N blobs spread across
+// 100 subdirectories, then times a full Walk over it. It's meant to be
+// run with -benchtime against a real cluster to compare the parallel
+// bulk-listing Walk against storagedriver.WalkFallback's one-directory-
+// at-a-time traversal.
+func BenchmarkDriverWalk(b *testing.B) {
+	hdfsNameNode := os.Getenv(hdfsNameNodeKeyEnv)
+	hdfsRootPath := os.Getenv(hdfsRootPathEnv)
+	if hdfsNameNode == "" || hdfsRootPath == "" {
+		b.Skip("The following environment variables must be set to enable this benchmark: HDFS_NAMENODE, HDFS_ROOT")
+	}
+
+	d, err := New(DriverConfig{
+		nameNode:   hdfsNameNode,
+		rootPath:   hdfsRootPath,
+		maxClients: 8,
+	})
+	if err != nil {
+		b.Fatalf("failed to construct driver: %v", err)
+	}
+	defer d.Close()
+
+	ctx := context.Background()
+	const numDirs = 100
+	for i := 0; i < b.N; i++ {
+		subPath := fmt.Sprintf("/walk-bench/dir-%d/blob-%d", i%numDirs, i)
+		if err := d.PutContent(ctx, subPath, []byte("benchmark")); err != nil {
+			b.Fatalf("failed to seed %s: %v", subPath, err)
+		}
+	}
+
+	b.ResetTimer()
+	var seen int
+	err = d.Walk(ctx, "/walk-bench", func(fileInfo storagedriver.FileInfo) error {
+		seen++
+		return nil
+	})
+	if err != nil {
+		b.Fatalf("walk failed: %v", err)
+	}
+	b.StopTimer()
+
+	d.Delete(ctx, "/walk-bench")
+}
@@ -0,0 +1,236 @@
+package hdfs
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/colinmarc/hdfs"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/jcmturner/gokrb5.v7/client"
+)
+
+const (
+	// defaultIdleTimeout is how long a pooled client may sit unused before
+	// the eviction sweep closes its NameNode connection.
+	defaultIdleTimeout = 5 * time.Minute
+
+	// defaultEvictInterval controls how often the pool scans for idle clients.
+	defaultEvictInterval = time.Minute
+
+	// krb5RenewInterval controls how often a Kerberos-authenticated pool
+	// re-logs-in with its keytab, so a long-running registry process never
+	// serves requests with an expired ticket-granting-ticket.
+	krb5RenewInterval = 30 * time.Minute
+)
+
+// pooledClient wraps an *hdfs.Client with the time it was returned to the
+// pool, so the eviction sweep can tell how long it has been idle.
+type pooledClient struct {
+	client   *hdfs.Client
+	lastUsed time.Time
+}
+
+// clientPool is a bounded, reference-counted pool of *hdfs.Client connections
+// to a single NameNode URI. base.NewRegulator already limits the number of
+// concurrent driver calls to maxOpen, but a single call (Walk) can itself
+// hold several pooled clients at once, so the pool enforces its own cap
+// independently: get blocks until a slot is free rather than dialing past
+// maxOpen.
+type clientPool struct {
+	dial func() (*hdfs.Client, error)
+
+	mu      sync.Mutex
+	maxOpen uint64
+	numOpen uint64
+	idle    []*pooledClient
+	closed  bool
+
+	// tokens bounds the number of simultaneously open *hdfs.Client
+	// connections to maxOpen. get consumes one whenever it can't satisfy
+	// the request from the idle set; put leaves the token with the
+	// connection (it's still open), while discard and evictIdle return it.
+	tokens chan struct{}
+	stop   chan struct{}
+}
+
+// newClientPool creates a pool bounded to maxOpen concurrently open clients
+// and starts its background idle-eviction loop. dial is used to open a new
+// NameNode connection whenever the idle set can't satisfy a get(). When
+// krb5Client is non-nil, the pool also keeps its ticket-granting-ticket
+// fresh for as long as the pool is open.
+func newClientPool(maxOpen uint64, krb5Client *client.Client, dial func() (*hdfs.Client, error)) *clientPool {
+	p := &clientPool{
+		dial:    dial,
+		maxOpen: maxOpen,
+		tokens:  make(chan struct{}, maxOpen),
+		stop:    make(chan struct{}),
+	}
+	for i := uint64(0); i < maxOpen; i++ {
+		p.tokens <- struct{}{}
+	}
+	go p.evictLoop()
+	if krb5Client != nil {
+		go p.renewTicketLoop(krb5Client)
+	}
+	return p
+}
+
+// get returns a healthy client from the idle set, pinging it first, dialing
+// a new one if none are idle or the idle ones are all dead. Once maxOpen
+// clients are open, get blocks until one is returned via put or discard, or
+// until ctx is done.
+func (p *clientPool) get(ctx context.Context) (*hdfs.Client, error) {
+	for {
+		p.mu.Lock()
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		pc := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if p.healthy(pc.client) {
+			return pc.client, nil
+		}
+
+		pc.client.Close()
+		p.mu.Lock()
+		p.numOpen--
+		p.mu.Unlock()
+		p.tokens <- struct{}{}
+	}
+
+	select {
+	case <-p.tokens:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	client, err := p.dial()
+	if err != nil {
+		p.tokens <- struct{}{}
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.numOpen++
+	p.mu.Unlock()
+	return client, nil
+}
+
+// put returns a client to the idle set for reuse.
+func (p *clientPool) put(client *hdfs.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		client.Close()
+		p.numOpen--
+		p.tokens <- struct{}{}
+		return
+	}
+	p.idle = append(p.idle, &pooledClient{client: client, lastUsed: time.Now()})
+}
+
+// discard closes a client that a caller has determined is no longer usable
+// (e.g. a request on it returned EOF or "use of closed network connection")
+// instead of returning it to the idle set, freeing its slot for a new dial.
+func (p *clientPool) discard(client *hdfs.Client) {
+	client.Close()
+	p.mu.Lock()
+	p.numOpen--
+	p.mu.Unlock()
+	p.tokens <- struct{}{}
+}
+
+// healthy pings the NameNode to make sure a pooled client's connection
+// wasn't torn down while it sat idle.
+func (p *clientPool) healthy(client *hdfs.Client) bool {
+	_, err := client.Stat("/")
+	return err == nil || !isDeadConnErr(err)
+}
+
+func (p *clientPool) evictLoop() {
+	ticker := time.NewTicker(defaultEvictInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.evictIdle(defaultIdleTimeout)
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// renewTicketLoop periodically re-authenticates krb5Client against the KDC
+// using its keytab, independently of any individual *hdfs.Client's lifetime.
+func (p *clientPool) renewTicketLoop(krb5Client *client.Client) {
+	ticker := time.NewTicker(krb5RenewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := krb5Client.Login(); err != nil {
+				log.Errorf("failed to renew Kerberos ticket: %v", err)
+			}
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// evictIdle closes and removes idle clients that haven't been used in
+// longer than olderThan.
+func (p *clientPool) evictIdle(olderThan time.Duration) {
+	cutoff := time.Now().Add(-olderThan)
+
+	p.mu.Lock()
+	kept := p.idle[:0]
+	var evicted []*pooledClient
+	for _, pc := range p.idle {
+		if pc.lastUsed.Before(cutoff) {
+			evicted = append(evicted, pc)
+		} else {
+			kept = append(kept, pc)
+		}
+	}
+	p.idle = kept
+	p.numOpen -= uint64(len(evicted))
+	p.mu.Unlock()
+
+	for _, pc := range evicted {
+		pc.client.Close()
+		p.tokens <- struct{}{}
+	}
+}
+
+// close shuts down the pool, closing every idle client and stopping the
+// eviction loop.
+func (p *clientPool) close() {
+	p.mu.Lock()
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	close(p.stop)
+	for _, pc := range idle {
+		pc.client.Close()
+	}
+}
+
+// isDeadConnErr reports whether err looks like it came from a NameNode
+// connection that has gone away, rather than a legitimate RPC failure.
+func isDeadConnErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "EOF") || strings.Contains(msg, "use of closed network connection")
+}